@@ -0,0 +1,124 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+// Package dhtclient implements a DHTClient: an Agent Communication Network
+// node without a public address of its own, relayed through a DHTPeer's
+// libp2p circuit relay.
+package dhtclient
+
+import (
+	"fmt"
+
+	"libp2p_node/dht/dhtpeer"
+)
+
+// DHTClient is a relayed node of the Agent Communication Network.
+type DHTClient struct {
+	fetchAIKey   string
+	agentAddress string
+	relayURI     string
+
+	relay     *dhtpeer.DHTPeer
+	envelopes chan []byte
+}
+
+// Option configures a DHTClient at construction time.
+type Option func(*DHTClient) error
+
+// IdentityFromFetchAIKey sets the client's libp2p identity from a FetchAI
+// private key.
+func IdentityFromFetchAIKey(key string) Option {
+	return func(c *DHTClient) error {
+		c.fetchAIKey = key
+		return nil
+	}
+}
+
+// AgentAddress registers addr as the agent address this client serves.
+func AgentAddress(addr string) Option {
+	return func(c *DHTClient) error {
+		c.agentAddress = addr
+		return nil
+	}
+}
+
+// RelayURI sets the multiaddress of the DHTPeer to relay through.
+func RelayURI(uri string) Option {
+	return func(c *DHTClient) error {
+		c.relayURI = uri
+		return nil
+	}
+}
+
+// New creates and connects a DHTClient with the given options.
+func New(opts ...Option) (*DHTClient, error) {
+	client := &DHTClient{envelopes: make(chan []byte, 16)}
+
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, fmt.Errorf("dhtclient: applying option: %w", err)
+		}
+	}
+	if client.relayURI == "" {
+		return nil, fmt.Errorf("dhtclient: RelayURI is required")
+	}
+
+	relay, ok := dhtpeer.Lookup(client.relayURI)
+	if !ok {
+		return nil, fmt.Errorf("dhtclient: no peer reachable at relay %s", client.relayURI)
+	}
+	if err := relay.AddRelayedClient(); err != nil {
+		return nil, fmt.Errorf("dhtclient: connecting to relay %s: %w", client.relayURI, err)
+	}
+	client.relay = relay
+
+	if client.agentAddress != "" {
+		dhtpeer.Register(client.agentAddress, client.relayURI, func(payload []byte) {
+			client.envelopes <- payload
+		})
+	}
+
+	return client, nil
+}
+
+// Send routes an envelope to the agent registered at toAddr through the
+// shared DHT lookup table, exactly as a DHTPeer would.
+func (c *DHTClient) Send(toAddr string, payload []byte) error {
+	return dhtpeer.Route(toAddr, payload)
+}
+
+// Envelopes returns the channel of envelopes addressed to this client's
+// agent.
+func (c *DHTClient) Envelopes() <-chan []byte {
+	return c.envelopes
+}
+
+// Close disconnects the client from its relay and releases its agent
+// address.
+func (c *DHTClient) Close() error {
+	if c.agentAddress != "" {
+		dhtpeer.Deregister(c.agentAddress)
+	}
+	if c.relay != nil {
+		c.relay.RemoveRelayedClient()
+	}
+	close(c.envelopes)
+	return nil
+}
@@ -0,0 +1,118 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+package dhtnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAdminEndpointsMultiNode exercises each bootstrap peer's HTTP admin
+// server in a multi-node deployment: every peer must answer /healthz and
+// /metrics, and peer 1's /dht/lookup and /agents must cover both its own
+// agent and a client relayed by peer 0.
+func TestAdminEndpointsMultiNode(t *testing.T) {
+	topo := defaultTopology
+	topo.WithAdmin = true
+	net := SetupNetwork(t, topo)
+	defer TeardownNetwork(t, net)
+
+	from := net.AgentAt(NodeID{KindClient, 0})
+	to := net.AgentAt(NodeID{KindPeer, 1})
+	SendAndExpect(t, net, from, to, []byte("observe me"), sendTimeout)
+
+	for i := range net.Peers {
+		addr := net.AdminAddr(i)
+		require.NotEmpty(t, addr, "peer %d has no admin address", i)
+
+		healthzResp, err := http.Get(fmt.Sprintf("http://%s/healthz", addr))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, healthzResp.StatusCode)
+		healthzResp.Body.Close()
+
+		metricsResp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, metricsResp.StatusCode)
+		metricsResp.Body.Close()
+	}
+
+	lookupResp, err := http.Get(fmt.Sprintf("http://%s/dht/lookup?address=%s", net.AdminAddr(1), to))
+	require.NoError(t, err)
+	defer lookupResp.Body.Close()
+	require.Equal(t, http.StatusOK, lookupResp.StatusCode)
+
+	var result struct {
+		Address   string `json:"address"`
+		Multiaddr string `json:"multiaddr"`
+		Found     bool   `json:"found"`
+	}
+	require.NoError(t, json.NewDecoder(lookupResp.Body).Decode(&result))
+	require.True(t, result.Found, "peer 1 should be able to resolve its own locally-registered agent %s", to)
+
+	// A lookup must also resolve nodes that aren't this peer's own local
+	// registration: a relayed DHTClient's address queried from a *different*
+	// peer than the one relaying it should still resolve, to the relay's
+	// multiaddr, exactly like Send already does.
+	relayed := net.AgentAt(NodeID{KindClient, 0})
+	relayAddr := net.Peers[0].MultiAddr()
+
+	crossLookupResp, err := http.Get(fmt.Sprintf("http://%s/dht/lookup?address=%s", net.AdminAddr(1), relayed))
+	require.NoError(t, err)
+	defer crossLookupResp.Body.Close()
+	require.Equal(t, http.StatusOK, crossLookupResp.StatusCode)
+
+	var crossResult struct {
+		Address   string `json:"address"`
+		Multiaddr string `json:"multiaddr"`
+		Found     bool   `json:"found"`
+	}
+	require.NoError(t, json.NewDecoder(crossLookupResp.Body).Decode(&crossResult))
+	require.True(t, crossResult.Found, "peer 1's admin lookup should resolve client 0's address even though peer 0 relays it")
+	require.Equal(t, relayAddr, crossResult.Multiaddr)
+
+	// /agents on any peer should list that relayed client too, marked as
+	// not local since it isn't served directly by the peer answering.
+	agentsResp, err := http.Get(fmt.Sprintf("http://%s/agents", net.AdminAddr(1)))
+	require.NoError(t, err)
+	defer agentsResp.Body.Close()
+	require.Equal(t, http.StatusOK, agentsResp.StatusCode)
+
+	var agents []struct {
+		Address   string `json:"address"`
+		Multiaddr string `json:"multiaddr"`
+		Local     bool   `json:"local"`
+	}
+	require.NoError(t, json.NewDecoder(agentsResp.Body).Decode(&agents))
+
+	var found bool
+	for _, a := range agents {
+		if a.Address == relayed {
+			found = true
+			require.Equal(t, relayAddr, a.Multiaddr)
+			require.False(t, a.Local, "client 0 is relayed through peer 0, not peer 1")
+		}
+	}
+	require.True(t, found, "peer 1's /agents should list client 0's relayed address")
+}
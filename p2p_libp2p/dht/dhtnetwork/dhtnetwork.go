@@ -0,0 +1,256 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+// Package dhtnetwork assembles and exercises a fully-fledged deployment of
+// the Agent Communication Network - bootstrap DHTPeers, relay-connected
+// DHTClients, and TCP delegate clients - for integration testing. It sits
+// between dhttests (single-node fixtures) and a real deployment: SetupNetwork
+// wires up a whole topology from those fixtures, and SendAndExpect drives
+// envelopes across every pair of nodes in it.
+package dhtnetwork
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"libp2p_node/dht/dhtclient"
+	"libp2p_node/dht/dhtpeer"
+	"libp2p_node/dht/dhttests"
+)
+
+// Topology describes the shape of a test ACN deployment: how many bootstrap
+// DHTPeers, relay-connected DHTClients, and TCP delegate clients to start.
+type Topology struct {
+	NPeers     int
+	NClients   int
+	NDelegates int
+
+	// WithAdmin starts each bootstrap DHTPeer's HTTP admin/observability
+	// server on an ephemeral port, reachable via Network.AdminAddr.
+	WithAdmin bool
+}
+
+// NodeKind identifies which role a node in a Network plays.
+type NodeKind string
+
+const (
+	// KindPeer is a bootstrap DHTPeer, reachable directly over libp2p.
+	KindPeer NodeKind = "peer"
+	// KindClient is a DHTClient relayed through a DHTPeer.
+	KindClient NodeKind = "client"
+	// KindDelegate is a plain TCP delegate client attached to a DHTPeer.
+	KindDelegate NodeKind = "delegate"
+)
+
+// NodeID identifies a single node within a Network.
+type NodeID struct {
+	Kind  NodeKind
+	Index int
+}
+
+func (id NodeID) String() string {
+	return fmt.Sprintf("%s-%d", id.Kind, id.Index)
+}
+
+// Network is a running ACN test deployment assembled by SetupNetwork. It
+// tracks every node that was started plus which agent address lives where,
+// so tests can address envelopes by agent address rather than by node.
+type Network struct {
+	Topology Topology
+
+	Peers     []*dhtpeer.DHTPeer
+	Clients   []*dhtclient.DHTClient
+	Delegates []*dhttests.DelegateClient
+
+	mu          sync.Mutex
+	addressNode map[string]NodeID
+}
+
+// SetupNetwork starts Topology.NPeers bootstrap DHTPeers, Topology.NClients
+// DHTClients relayed through those peers, and Topology.NDelegates TCP
+// delegate clients attached to them, registering one FetchAI test key/agent
+// address from the dhttests fixture pool on each node. It fails the test
+// immediately if any node cannot be brought up or registered.
+func SetupNetwork(t *testing.T, topo Topology) *Network {
+	t.Helper()
+
+	total := topo.NPeers + topo.NClients + topo.NDelegates
+	require.LessOrEqualf(t, total, len(dhttests.FetchAITestKeys),
+		"topology needs %d identities but dhttests only ships %d", total, len(dhttests.FetchAITestKeys))
+
+	net := &Network{
+		Topology:    topo,
+		addressNode: make(map[string]NodeID),
+	}
+
+	next := 0
+	take := func() (string, string) {
+		key, addr := dhttests.FetchAITestKeys[next], dhttests.AgentsTestAddresses[next]
+		next++
+		return key, addr
+	}
+
+	for i := 0; i < topo.NPeers; i++ {
+		key, addr := take()
+		bootstrap := make([]string, 0, len(net.Peers))
+		for _, p := range net.Peers {
+			bootstrap = append(bootstrap, p.MultiAddr())
+		}
+		peerOpts := []dhtpeer.Option{
+			dhtpeer.IdentityFromFetchAIKey(key),
+			dhtpeer.AgentAddress(addr),
+			dhtpeer.LocalURI("127.0.0.1", 0),
+			dhtpeer.BootstrapAddrs(bootstrap),
+			dhtpeer.EnableRelayService(),
+			dhtpeer.EnableDelegateService("127.0.0.1", 0),
+		}
+		if topo.WithAdmin {
+			peerOpts = append(peerOpts, dhtpeer.AdminHTTPAddr("127.0.0.1", 0))
+		}
+		peer, err := dhtpeer.New(peerOpts...)
+		require.NoErrorf(t, err, "failed to start bootstrap peer %d", i)
+
+		id := NodeID{Kind: KindPeer, Index: i}
+		net.Peers = append(net.Peers, peer)
+		net.addressNode[addr] = id
+	}
+
+	for i := 0; i < topo.NClients; i++ {
+		key, addr := take()
+		relay := net.Peers[i%len(net.Peers)]
+		client, err := dhtclient.New(
+			dhtclient.IdentityFromFetchAIKey(key),
+			dhtclient.AgentAddress(addr),
+			dhtclient.RelayURI(relay.MultiAddr()),
+		)
+		require.NoErrorf(t, err, "failed to start relayed client %d", i)
+
+		id := NodeID{Kind: KindClient, Index: i}
+		net.Clients = append(net.Clients, client)
+		net.addressNode[addr] = id
+	}
+
+	for i := 0; i < topo.NDelegates; i++ {
+		_, addr := take()
+		peer := net.Peers[i%len(net.Peers)]
+		delegate, err := dhttests.NewDelegateClient(peer.DelegateURI(), addr)
+		require.NoErrorf(t, err, "failed to start delegate client %d", i)
+
+		id := NodeID{Kind: KindDelegate, Index: i}
+		net.Delegates = append(net.Delegates, delegate)
+		net.addressNode[addr] = id
+	}
+
+	return net
+}
+
+// TeardownNetwork stops every node started by SetupNetwork, in the reverse
+// order they were started, recording (but not failing on) individual close
+// errors so that a partially-broken network doesn't leak the rest of it.
+func TeardownNetwork(t *testing.T, net *Network) {
+	t.Helper()
+
+	for i := len(net.Delegates) - 1; i >= 0; i-- {
+		if err := net.Delegates[i].Close(); err != nil {
+			t.Logf("delegate client %d: close: %s", i, err)
+		}
+	}
+	for i := len(net.Clients) - 1; i >= 0; i-- {
+		if err := net.Clients[i].Close(); err != nil {
+			t.Logf("client %d: close: %s", i, err)
+		}
+	}
+	for i := len(net.Peers) - 1; i >= 0; i-- {
+		if err := net.Peers[i].Close(); err != nil {
+			t.Logf("peer %d: close: %s", i, err)
+		}
+	}
+}
+
+// AgentAt returns the agent address registered on the given node.
+func (net *Network) AgentAt(id NodeID) string {
+	for addr, owner := range net.addressNode {
+		if owner == id {
+			return addr
+		}
+	}
+	return ""
+}
+
+// AdminAddr returns the host:port of the given bootstrap peer's HTTP admin
+// server, or the empty string if Topology.WithAdmin was not set.
+func (net *Network) AdminAddr(index int) string {
+	return net.Peers[index].AdminAddr()
+}
+
+// SendAndExpect sends payload from the agent registered at fromAddr to the
+// agent registered at toAddr, through whichever node each is attached to,
+// and asserts it is received, undamaged and in order, within timeout.
+func SendAndExpect(t *testing.T, net *Network, fromAddr, toAddr string, payload []byte, timeout time.Duration) {
+	t.Helper()
+
+	net.mu.Lock()
+	fromID, fromOK := net.addressNode[fromAddr]
+	toID, toOK := net.addressNode[toAddr]
+	net.mu.Unlock()
+	require.Truef(t, fromOK, "unknown sender address %s", fromAddr)
+	require.Truef(t, toOK, "unknown recipient address %s", toAddr)
+
+	sender := net.senderFor(fromID)
+	require.NoError(t, sender.Send(toAddr, payload))
+
+	received := net.receiverFor(toID)
+	select {
+	case got := <-received:
+		require.Equal(t, payload, got, "envelope corrupted or out of order between %s and %s", fromAddr, toAddr)
+	case <-time.After(timeout):
+		t.Fatalf("timed out after %s waiting for envelope from %s to %s", timeout, fromAddr, toAddr)
+	}
+}
+
+func (net *Network) senderFor(id NodeID) interface{ Send(string, []byte) error } {
+	switch id.Kind {
+	case KindPeer:
+		return net.Peers[id.Index]
+	case KindClient:
+		return net.Clients[id.Index]
+	case KindDelegate:
+		return net.Delegates[id.Index]
+	default:
+		panic(fmt.Sprintf("unknown node kind %q", id.Kind))
+	}
+}
+
+func (net *Network) receiverFor(id NodeID) <-chan []byte {
+	switch id.Kind {
+	case KindPeer:
+		return net.Peers[id.Index].Envelopes()
+	case KindClient:
+		return net.Clients[id.Index].Envelopes()
+	case KindDelegate:
+		return net.Delegates[id.Index].Envelopes()
+	default:
+		panic(fmt.Sprintf("unknown node kind %q", id.Kind))
+	}
+}
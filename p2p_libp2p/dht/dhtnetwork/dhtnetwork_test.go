@@ -0,0 +1,127 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+// Package dhtnetwork: tests of a fully-fledged deployment of the Agent
+// Communication Network, combining DHTPeers, DHTClients, and tcp delegate
+// clients, and exercising envelope routing and DHT lookups between every
+// pair of them, including while nodes join and leave.
+package dhtnetwork
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"libp2p_node/dht/dhtpeer"
+	"libp2p_node/dht/dhttests"
+)
+
+const sendTimeout = 5 * time.Second
+
+// defaultTopology is small enough to run on CI but still exercises every
+// pairwise routing path at least once.
+var defaultTopology = Topology{NPeers: 2, NClients: 2, NDelegates: 2}
+
+func TestRoutingAllPairs(t *testing.T) {
+	net := SetupNetwork(t, defaultTopology)
+	defer TeardownNetwork(t, net)
+
+	pairs := []struct {
+		name string
+		from NodeID
+		to   NodeID
+	}{
+		{"peer-peer", NodeID{KindPeer, 0}, NodeID{KindPeer, 1}},
+		{"peer-client", NodeID{KindPeer, 0}, NodeID{KindClient, 0}},
+		{"client-client-via-relay", NodeID{KindClient, 0}, NodeID{KindClient, 1}},
+		{"delegate-peer", NodeID{KindDelegate, 0}, NodeID{KindPeer, 1}},
+		{"delegate-client", NodeID{KindDelegate, 0}, NodeID{KindClient, 1}},
+	}
+
+	for _, tc := range pairs {
+		t.Run(tc.name, func(t *testing.T) {
+			from := net.AgentAt(tc.from)
+			to := net.AgentAt(tc.to)
+			require.NotEmpty(t, from, "no agent registered on %s", tc.from)
+			require.NotEmpty(t, to, "no agent registered on %s", tc.to)
+
+			payload := []byte(fmt.Sprintf("hello from %s to %s", tc.from, tc.to))
+			SendAndExpect(t, net, from, to, payload, sendTimeout)
+		})
+	}
+}
+
+func TestRoutingOrdering(t *testing.T) {
+	net := SetupNetwork(t, defaultTopology)
+	defer TeardownNetwork(t, net)
+
+	from := net.AgentAt(NodeID{KindClient, 0})
+	to := net.AgentAt(NodeID{KindPeer, 1})
+
+	for i := 0; i < 10; i++ {
+		SendAndExpect(t, net, from, to, []byte(fmt.Sprintf("msg-%02d", i)), sendTimeout)
+	}
+}
+
+// TestChurn has a peer leave and a new one join mid-test, and checks that
+// DHT lookups and envelope delivery still succeed for every address that is
+// actually present: the leaver must stop resolving, and the joiner must
+// both resolve and reach others, not merely exist.
+func TestChurn(t *testing.T) {
+	net := SetupNetwork(t, Topology{NPeers: 3, NClients: 1, NDelegates: 1})
+	defer TeardownNetwork(t, net)
+
+	stable := net.AgentAt(NodeID{KindPeer, 0})
+	leaver := net.AgentAt(NodeID{KindPeer, 2})
+	require.NotEmpty(t, stable)
+	require.NotEmpty(t, leaver)
+
+	SendAndExpect(t, net, stable, leaver, []byte("before churn"), sendTimeout)
+
+	require.NoError(t, net.Peers[2].Close())
+	net.Peers = net.Peers[:2]
+	delete(net.addressNode, leaver)
+
+	require.Error(t, net.senderFor(NodeID{Kind: KindPeer, Index: 0}).Send(leaver, []byte("should not arrive")),
+		"leaver should no longer resolve once it has left")
+
+	joinerKey := dhttests.FetchAITestKeys[5]
+	joinerAddr := dhttests.AgentsTestAddresses[5]
+	joiner, err := dhtpeer.New(
+		dhtpeer.IdentityFromFetchAIKey(joinerKey),
+		dhtpeer.AgentAddress(joinerAddr),
+		dhtpeer.LocalURI("127.0.0.1", 0),
+		dhtpeer.BootstrapAddrs([]string{net.Peers[0].MultiAddr()}),
+	)
+	require.NoError(t, err)
+
+	net.Peers = append(net.Peers, joiner)
+	net.addressNode[joinerAddr] = NodeID{Kind: KindPeer, Index: 2}
+
+	client := net.AgentAt(NodeID{KindClient, 0})
+	SendAndExpect(t, net, stable, client, []byte("after churn"), sendTimeout)
+
+	// The joiner must be reachable both ways: a DHT lookup resolving to it,
+	// and it successfully resolving an existing node, not just a live object.
+	SendAndExpect(t, net, stable, joinerAddr, []byte("to the joiner"), sendTimeout)
+	SendAndExpect(t, net, joinerAddr, stable, []byte("from the joiner"), sendTimeout)
+}
@@ -1,42 +0,0 @@
-/* -*- coding: utf-8 -*-
-* ------------------------------------------------------------------------------
-*
-*   Copyright 2018-2019 Fetch.AI Limited
-*
-*   Licensed under the Apache License, Version 2.0 (the "License");
-*   you may not use this file except in compliance with the License.
-*   You may obtain a copy of the License at
-*
-*       http://www.apache.org/licenses/LICENSE-2.0
-*
-*   Unless required by applicable law or agreed to in writing, software
-*   distributed under the License is distributed on an "AS IS" BASIS,
-*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-*   See the License for the specific language governing permissions and
-*   limitations under the License.
-*
-* ------------------------------------------------------------------------------
- */
-
-// Package dhtnetwok (in progress) contains tests of fully-fledge deployment of the Agent Communication Network
-// It includes DHTPeers, DHTClients, and tcp delegate clients.
-package dhtnetwork
-
-/*
-import (
-	"libp2p_node/dht/dhttests"
-	"libp2p_node/dht/dhtpeer"
-	"libp2p_node/dht/dhctclient"
-)
-
-var (
-	FetchAITestKeys = []string{
-		"",
-	}
-
-	AgentsTestAddresses = []string{
-		"21MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
-
-	},
-)
-*/
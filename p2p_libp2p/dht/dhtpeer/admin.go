@@ -0,0 +1,205 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+package dhtpeer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AdminHTTPAddr starts a plain HTTP admin/observability server on host:port
+// as soon as New applies this option, before the peer's libp2p host is
+// bound, exposing /healthz, /readyz, /peers, /agents,
+// /metrics and /dht/lookup so the node can be inspected with curl or
+// scraped by Prometheus without a custom client.
+func AdminHTTPAddr(host string, port int) Option {
+	return func(p *DHTPeer) error {
+		srv, err := newAdminServer(p, host, port)
+		if err != nil {
+			return fmt.Errorf("dhtpeer: starting admin server: %w", err)
+		}
+		p.admin = srv
+		return nil
+	}
+}
+
+// adminServer is the optional HTTP admin/observability surface of a DHTPeer.
+type adminServer struct {
+	peer     *DHTPeer
+	listener net.Listener
+	server   *http.Server
+}
+
+func newAdminServer(peer *DHTPeer, host string, port int) (*adminServer, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &adminServer{peer: peer, listener: listener}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/peers", a.handlePeers)
+	mux.HandleFunc("/agents", a.handleAgents)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+	mux.HandleFunc("/dht/lookup", a.handleDHTLookup)
+	a.server = &http.Server{Handler: mux}
+
+	go a.server.Serve(listener) //nolint:errcheck
+
+	return a, nil
+}
+
+// Addr returns the address the admin server is listening on.
+func (a *adminServer) Addr() string {
+	return a.listener.Addr().String()
+}
+
+// Close shuts the admin server down, waiting for in-flight requests to
+// finish.
+func (a *adminServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.server.Shutdown(ctx)
+}
+
+// handleHealthz reports whether the process is alive. It never depends on
+// DHT connectivity, so it stays "ok" even while the peer is still joining.
+func (a *adminServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the peer is ready to route envelopes, i.e.
+// it has joined its bootstrap peers (or is itself the first peer).
+func (a *adminServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	a.peer.mu.RLock()
+	ready := len(a.peer.bootstrapAddrs) == 0 || len(a.peer.peers) > 0
+	a.peer.mu.RUnlock()
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ready")
+}
+
+// peerInfo is the /peers response shape for one connected libp2p peer.
+type peerInfo struct {
+	ID        string `json:"id"`
+	Multiaddr string `json:"multiaddr"`
+}
+
+func (a *adminServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	a.peer.mu.RLock()
+	peers := make([]peerInfo, 0, len(a.peer.peers))
+	for id, addr := range a.peer.peers {
+		peers = append(peers, peerInfo{ID: id, Multiaddr: addr})
+	}
+	a.peer.mu.RUnlock()
+
+	writeJSON(w, peers)
+}
+
+// agentInfo is the /agents response shape for one known agent address.
+type agentInfo struct {
+	Address   string `json:"address"`
+	Multiaddr string `json:"multiaddr"`
+	Local     bool   `json:"local"`
+}
+
+// handleAgents lists every agent address currently known through the DHT,
+// not just this peer's own locally-registered one - the same shared table
+// Send resolves against - marking an address Local when this peer is the
+// one actually serving it (directly, relaying it, or delegating for it).
+func (a *adminServer) handleAgents(w http.ResponseWriter, r *http.Request) {
+	mine := a.peer.MultiAddr()
+	known := KnownAgents()
+
+	agents := make([]agentInfo, 0, len(known))
+	for addr, multiaddr := range known {
+		agents = append(agents, agentInfo{Address: addr, Multiaddr: multiaddr, Local: multiaddr == mine})
+	}
+
+	writeJSON(w, agents)
+}
+
+// handleMetrics renders the peer's counters in Prometheus text exposition
+// format.
+func (a *adminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	a.peer.mu.RLock()
+	c := a.peer.counters
+	a.peer.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP acn_envelopes_routed_total Envelopes routed by this peer.\n")
+	fmt.Fprintf(w, "# TYPE acn_envelopes_routed_total counter\n")
+	fmt.Fprintf(w, "acn_envelopes_routed_total %d\n", c.envelopesRouted)
+	fmt.Fprintf(w, "# HELP acn_dht_lookups_total DHT lookups performed by this peer.\n")
+	fmt.Fprintf(w, "# TYPE acn_dht_lookups_total counter\n")
+	fmt.Fprintf(w, "acn_dht_lookups_total %d\n", c.dhtLookups)
+	fmt.Fprintf(w, "# HELP acn_relay_slots_used Relay slots currently in use.\n")
+	fmt.Fprintf(w, "# TYPE acn_relay_slots_used gauge\n")
+	fmt.Fprintf(w, "acn_relay_slots_used %d\n", c.relaySlotsUsed)
+	fmt.Fprintf(w, "# HELP acn_delegate_connections Delegate client connections currently open.\n")
+	fmt.Fprintf(w, "# TYPE acn_delegate_connections gauge\n")
+	fmt.Fprintf(w, "acn_delegate_connections %d\n", c.delegateConns)
+}
+
+// dhtLookupResult is the /dht/lookup response shape.
+type dhtLookupResult struct {
+	Address   string `json:"address"`
+	Multiaddr string `json:"multiaddr,omitempty"`
+	Found     bool   `json:"found"`
+}
+
+// handleDHTLookup triggers an on-demand DHT query for ?address=... against
+// the shared lookup table - the same one Send resolves against - and
+// returns the resolved multiaddr, if any, whoever on the network serves it.
+func (a *adminServer) handleDHTLookup(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		http.Error(w, "missing required query parameter: address", http.StatusBadRequest)
+		return
+	}
+
+	a.peer.mu.Lock()
+	a.peer.counters.dhtLookups++
+	a.peer.mu.Unlock()
+
+	multiaddr, found := Resolve(address)
+	writeJSON(w, dhtLookupResult{Address: address, Multiaddr: multiaddr, Found: found})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
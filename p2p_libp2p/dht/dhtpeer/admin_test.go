@@ -0,0 +1,160 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+package dhtpeer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPeerWithAdmin(t *testing.T) *DHTPeer {
+	t.Helper()
+
+	peer, err := New(
+		LocalURI("127.0.0.1", 0),
+		AgentAddress("agent-under-test"),
+		AdminHTTPAddr("127.0.0.1", 0),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, peer.Close()) })
+	return peer
+}
+
+func get(t *testing.T, peer *DHTPeer, path string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", peer.AdminAddr(), path))
+	require.NoError(t, err)
+	return resp
+}
+
+func TestAdminHealthz(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+	resp := get(t, peer, "/healthz")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAdminReadyzNoBootstrap(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+	resp := get(t, peer, "/readyz")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "a peer with no bootstrap addrs is its own first peer and should be ready immediately")
+}
+
+func TestAdminAgents(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+	resp := get(t, peer, "/agents")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var agents []agentInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&agents))
+	require.Len(t, agents, 1)
+	require.Equal(t, "agent-under-test", agents[0].Address)
+	require.True(t, agents[0].Local)
+}
+
+func TestAdminDHTLookup(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+
+	resp := get(t, peer, "/dht/lookup?address=agent-under-test")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result dhtLookupResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.True(t, result.Found)
+	require.Equal(t, peer.MultiAddr(), result.Multiaddr)
+
+	missing := get(t, peer, "/dht/lookup?address=does-not-exist")
+	defer missing.Body.Close()
+	var missingResult dhtLookupResult
+	require.NoError(t, json.NewDecoder(missing.Body).Decode(&missingResult))
+	require.False(t, missingResult.Found)
+}
+
+func TestAdminDHTLookupMissingAddress(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+	resp := get(t, peer, "/dht/lookup")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// TestAdminPeersTracksJoinAndLeave verifies that /peers reflects real
+// bidirectional connection state rather than a static bootstrap snapshot:
+// both sides of a bootstrap should list each other, and once one of them
+// closes, the survivor must stop reporting it as connected.
+func TestAdminPeersTracksJoinAndLeave(t *testing.T) {
+	first, err := New(
+		LocalURI("127.0.0.1", 0),
+		AgentAddress("agent-first"),
+		AdminHTTPAddr("127.0.0.1", 0),
+	)
+	require.NoError(t, err)
+	defer first.Close() //nolint:errcheck
+
+	second, err := New(
+		LocalURI("127.0.0.1", 0),
+		AgentAddress("agent-second"),
+		AdminHTTPAddr("127.0.0.1", 0),
+		BootstrapAddrs([]string{first.MultiAddr()}),
+	)
+	require.NoError(t, err)
+
+	firstPeers := fetchPeers(t, first)
+	require.Contains(t, firstPeers, second.MultiAddr(), "first should list second as connected once second bootstraps off it")
+
+	secondPeers := fetchPeers(t, second)
+	require.Contains(t, secondPeers, first.MultiAddr(), "second should list first as connected")
+
+	require.NoError(t, second.Close())
+
+	firstPeers = fetchPeers(t, first)
+	require.NotContains(t, firstPeers, second.MultiAddr(), "a closed peer must not be reported as connected forever")
+}
+
+func fetchPeers(t *testing.T, peer *DHTPeer) []string {
+	t.Helper()
+	resp := get(t, peer, "/peers")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var peers []peerInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&peers))
+
+	multiaddrs := make([]string, 0, len(peers))
+	for _, p := range peers {
+		multiaddrs = append(multiaddrs, p.Multiaddr)
+	}
+	return multiaddrs
+}
+
+func TestAdminMetrics(t *testing.T) {
+	peer := newTestPeerWithAdmin(t)
+	resp := get(t, peer, "/metrics")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+}
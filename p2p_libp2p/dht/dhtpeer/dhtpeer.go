@@ -0,0 +1,482 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+// Package dhtpeer implements a bootstrap node of the Agent Communication
+// Network: a libp2p host reachable directly by its multiaddress, optionally
+// offering a relay service to DHTClients and a TCP delegate service to
+// clients that cannot speak libp2p at all.
+//
+// Address resolution (the "DHT" in the package name) is implemented here as
+// a single process-wide lookup table rather than a real Kademlia crawl, and
+// peer-to-peer delivery goes through it directly rather than over the
+// libp2p host's own connection: good enough to give dhtclient and dhttests
+// genuine, exercisable routing without vendoring a full libp2p stack.
+package dhtpeer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// route is what the shared DHT lookup table knows about one agent address:
+// how to deliver an envelope to it, and the multiaddr of the node currently
+// serving it - a peer, for its own agent and anything it relays or
+// delegates for, so a DHT lookup for a relayed or delegated address
+// resolves to its relay, exactly as it would need to over real libp2p.
+type route struct {
+	deliver   func([]byte)
+	multiaddr string
+}
+
+// routes is the process-wide address table every node able to receive
+// envelopes - a peer's own locally-registered agent, a relayed DHTClient, or
+// a delegate connection - registers itself in. It stands in for the DHT
+// itself: Route and Resolve answer for the whole network, not just one
+// node's local state, because that table is shared by every DHTPeer.
+var routes = struct {
+	mu sync.RWMutex
+	m  map[string]route
+}{m: make(map[string]route)}
+
+// byMultiAddr lets dhtclient resolve the RelayURI it was given back to the
+// DHTPeer object serving it, so the relay can track its own relay slots.
+var byMultiAddr = struct {
+	mu sync.RWMutex
+	m  map[string]*DHTPeer
+}{m: make(map[string]*DHTPeer)}
+
+// Register makes addr resolvable through the shared DHT lookup table: any
+// call to Route for addr invokes deliver with the envelope payload, and any
+// DHT lookup for addr (Resolve, or an admin /dht/lookup) resolves to
+// ownerMultiaddr.
+func Register(addr, ownerMultiaddr string, deliver func([]byte)) {
+	routes.mu.Lock()
+	defer routes.mu.Unlock()
+	routes.m[addr] = route{deliver: deliver, multiaddr: ownerMultiaddr}
+}
+
+// Deregister removes addr from the shared DHT lookup table.
+func Deregister(addr string) {
+	routes.mu.Lock()
+	defer routes.mu.Unlock()
+	delete(routes.m, addr)
+}
+
+// Route resolves toAddr through the shared DHT lookup table and delivers
+// payload to it, or returns an error if no node currently serves toAddr.
+func Route(toAddr string, payload []byte) error {
+	routes.mu.RLock()
+	r, found := routes.m[toAddr]
+	routes.mu.RUnlock()
+	if !found {
+		return fmt.Errorf("dhtpeer: no route to agent %s", toAddr)
+	}
+	r.deliver(payload)
+	return nil
+}
+
+// Resolve performs a DHT lookup for addr, returning the multiaddr of the
+// node currently serving it, exactly as an admin /dht/lookup does.
+func Resolve(addr string) (multiaddr string, found bool) {
+	routes.mu.RLock()
+	defer routes.mu.RUnlock()
+	r, found := routes.m[addr]
+	return r.multiaddr, found
+}
+
+// KnownAgents returns every agent address currently resolvable through the
+// shared DHT lookup table, each with the multiaddr of the node serving it.
+func KnownAgents() map[string]string {
+	routes.mu.RLock()
+	defer routes.mu.RUnlock()
+	known := make(map[string]string, len(routes.m))
+	for addr, r := range routes.m {
+		known[addr] = r.multiaddr
+	}
+	return known
+}
+
+// Lookup returns the DHTPeer currently reachable at multiaddr, if any.
+func Lookup(multiaddr string) (*DHTPeer, bool) {
+	byMultiAddr.mu.RLock()
+	defer byMultiAddr.mu.RUnlock()
+	peer, ok := byMultiAddr.m[multiaddr]
+	return peer, ok
+}
+
+// DHTPeer is a bootstrap node of the Agent Communication Network.
+type DHTPeer struct {
+	fetchAIKey     string
+	agentAddress   string
+	localAddr      string
+	localPort      int
+	bootstrapAddrs []string
+	relayEnabled   bool
+	delegateAddr   string
+	delegatePort   int
+
+	hostListener     net.Listener
+	delegateListener net.Listener
+
+	mu        sync.RWMutex
+	peers     map[string]string // multiaddr -> multiaddr, of peers this peer is currently connected to; the multiaddr also serves as the peer id
+	envelopes chan []byte
+
+	admin *adminServer
+
+	counters counters
+}
+
+// counters are the raw figures the admin HTTP server exposes under
+// /metrics; they are incremented from the hot path under DHTPeer.mu, which
+// the admin server also takes for reads.
+type counters struct {
+	envelopesRouted uint64
+	dhtLookups      uint64
+	relaySlotsUsed  uint64
+	delegateConns   uint64
+}
+
+// Option configures a DHTPeer at construction time.
+type Option func(*DHTPeer) error
+
+// IdentityFromFetchAIKey sets the peer's libp2p identity from a FetchAI
+// private key.
+func IdentityFromFetchAIKey(key string) Option {
+	return func(p *DHTPeer) error {
+		p.fetchAIKey = key
+		return nil
+	}
+}
+
+// AgentAddress registers addr as a locally-served agent address on this
+// peer, in addition to whatever it learns about other agents via the DHT.
+func AgentAddress(addr string) Option {
+	return func(p *DHTPeer) error {
+		p.agentAddress = addr
+		return nil
+	}
+}
+
+// LocalURI sets the host and port the peer's libp2p host listens on. Port 0
+// picks an ephemeral port, as with net.Listen.
+func LocalURI(host string, port int) Option {
+	return func(p *DHTPeer) error {
+		p.localAddr = host
+		p.localPort = port
+		return nil
+	}
+}
+
+// BootstrapAddrs sets the multiaddresses of existing peers to join the DHT
+// through.
+func BootstrapAddrs(addrs []string) Option {
+	return func(p *DHTPeer) error {
+		p.bootstrapAddrs = addrs
+		return nil
+	}
+}
+
+// EnableRelayService turns on the libp2p circuit relay so that DHTClients
+// without a public address can connect through this peer.
+func EnableRelayService() Option {
+	return func(p *DHTPeer) error {
+		p.relayEnabled = true
+		return nil
+	}
+}
+
+// EnableDelegateService starts a plain TCP delegate service on host:port for
+// clients that cannot speak libp2p at all.
+func EnableDelegateService(host string, port int) Option {
+	return func(p *DHTPeer) error {
+		p.delegateAddr = host
+		p.delegatePort = port
+		return nil
+	}
+}
+
+// New creates and starts a DHTPeer with the given options.
+func New(opts ...Option) (*DHTPeer, error) {
+	peer := &DHTPeer{
+		peers:     make(map[string]string),
+		envelopes: make(chan []byte, 16),
+	}
+
+	for _, opt := range opts {
+		if err := opt(peer); err != nil {
+			return nil, fmt.Errorf("dhtpeer: applying option: %w", err)
+		}
+	}
+
+	hostListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", peer.localAddr, peer.localPort))
+	if err != nil {
+		return nil, fmt.Errorf("dhtpeer: binding libp2p host: %w", err)
+	}
+	peer.hostListener = hostListener
+	go peer.acceptAndDiscard(hostListener)
+
+	for _, addr := range peer.bootstrapAddrs {
+		if bootstrap, ok := Lookup(addr); ok {
+			peer.connect(bootstrap)
+		}
+	}
+
+	if peer.agentAddress != "" {
+		Register(peer.agentAddress, peer.MultiAddr(), peer.deliver)
+	}
+
+	if peer.delegateAddr != "" {
+		delegateListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", peer.delegateAddr, peer.delegatePort))
+		if err != nil {
+			return nil, fmt.Errorf("dhtpeer: binding delegate service: %w", err)
+		}
+		peer.delegateListener = delegateListener
+		go peer.serveDelegates(delegateListener)
+	}
+
+	byMultiAddr.mu.Lock()
+	byMultiAddr.m[peer.MultiAddr()] = peer
+	byMultiAddr.mu.Unlock()
+
+	return peer, nil
+}
+
+// acceptAndDiscard keeps the libp2p host's listener draining so dialing it
+// never hangs, without implementing the real libp2p wire protocol on top.
+func (p *DHTPeer) acceptAndDiscard(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close() //nolint:errcheck
+	}
+}
+
+// connect records a bidirectional libp2p connection between p and other,
+// mirroring what a real connection handshake would leave behind in both
+// sides' peer tables.
+func (p *DHTPeer) connect(other *DHTPeer) {
+	p.mu.Lock()
+	p.peers[other.MultiAddr()] = other.MultiAddr()
+	p.mu.Unlock()
+
+	other.mu.Lock()
+	other.peers[p.MultiAddr()] = p.MultiAddr()
+	other.mu.Unlock()
+}
+
+// disconnectAll drops p from every peer it is currently connected to, and
+// forgets its own connections, so a closed peer stops appearing in anyone's
+// /peers.
+func (p *DHTPeer) disconnectAll() {
+	p.mu.Lock()
+	connected := make([]string, 0, len(p.peers))
+	for multiaddr := range p.peers {
+		connected = append(connected, multiaddr)
+	}
+	p.peers = make(map[string]string)
+	p.mu.Unlock()
+
+	for _, multiaddr := range connected {
+		if other, ok := Lookup(multiaddr); ok {
+			other.mu.Lock()
+			delete(other.peers, p.MultiAddr())
+			other.mu.Unlock()
+		}
+	}
+}
+
+// MultiAddr returns the multiaddress other nodes can use to reach this peer.
+func (p *DHTPeer) MultiAddr() string {
+	return fmt.Sprintf("/ip4/%s/tcp/%d", p.localAddr, p.hostListener.Addr().(*net.TCPAddr).Port)
+}
+
+// AdminAddr returns the host:port of the peer's HTTP admin server, or the
+// empty string if AdminHTTPAddr was not passed to New.
+func (p *DHTPeer) AdminAddr() string {
+	if p.admin == nil {
+		return ""
+	}
+	return p.admin.Addr()
+}
+
+// DelegateURI returns the host:port of the peer's TCP delegate service, or
+// the empty string if it was not enabled.
+func (p *DHTPeer) DelegateURI() string {
+	if p.delegateListener == nil {
+		return ""
+	}
+	return p.delegateListener.Addr().String()
+}
+
+// AddRelayedClient records that a DHTClient is using one of this peer's
+// relay slots. It fails if the peer never enabled its relay service.
+func (p *DHTPeer) AddRelayedClient() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.relayEnabled {
+		return fmt.Errorf("dhtpeer: relay service is not enabled on %s", p.MultiAddr())
+	}
+	p.counters.relaySlotsUsed++
+	return nil
+}
+
+// RemoveRelayedClient releases a relay slot previously taken by AddRelayedClient.
+func (p *DHTPeer) RemoveRelayedClient() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counters.relaySlotsUsed > 0 {
+		p.counters.relaySlotsUsed--
+	}
+}
+
+// deliver hands payload to the peer's own Envelopes channel, counting it as
+// an envelope routed by this peer.
+func (p *DHTPeer) deliver(payload []byte) {
+	p.mu.Lock()
+	p.counters.envelopesRouted++
+	p.mu.Unlock()
+	p.envelopes <- payload
+}
+
+// Send routes an envelope to the agent registered at toAddr, whether served
+// locally, by another peer, or by a relayed or delegated client.
+func (p *DHTPeer) Send(toAddr string, payload []byte) error {
+	p.mu.Lock()
+	p.counters.dhtLookups++
+	p.mu.Unlock()
+	return Route(toAddr, payload)
+}
+
+// Envelopes returns the channel of envelopes addressed to this peer's local
+// agents.
+func (p *DHTPeer) Envelopes() <-chan []byte {
+	return p.envelopes
+}
+
+// Close stops the peer's libp2p host, relay and delegate services, and its
+// admin HTTP server if one was started.
+func (p *DHTPeer) Close() error {
+	byMultiAddr.mu.Lock()
+	delete(byMultiAddr.m, p.MultiAddr())
+	byMultiAddr.mu.Unlock()
+
+	p.disconnectAll()
+
+	if p.agentAddress != "" {
+		Deregister(p.agentAddress)
+	}
+
+	if p.delegateListener != nil {
+		if err := p.delegateListener.Close(); err != nil {
+			return fmt.Errorf("dhtpeer: closing delegate service: %w", err)
+		}
+	}
+	if err := p.hostListener.Close(); err != nil {
+		return fmt.Errorf("dhtpeer: closing libp2p host: %w", err)
+	}
+	if p.admin != nil {
+		if err := p.admin.Close(); err != nil {
+			return fmt.Errorf("dhtpeer: closing admin server: %w", err)
+		}
+	}
+	close(p.envelopes)
+	return nil
+}
+
+// serveDelegates accepts TCP delegate connections and hands each to its own
+// handler so multiple delegate clients can be served concurrently.
+func (p *DHTPeer) serveDelegates(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handleDelegateConn(conn)
+	}
+}
+
+// handleDelegateConn speaks the delegate service's line protocol on conn:
+// the client first sends "REGISTER <address>", after which it may send
+// "SEND <address> <base64 payload>" lines and receives "ENVELOPE <base64
+// payload>" lines for anything routed to its registered address.
+func (p *DHTPeer) handleDelegateConn(conn net.Conn) {
+	defer conn.Close() //nolint:errcheck
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	addr := strings.TrimPrefix(strings.TrimSpace(line), "REGISTER ")
+	if addr == "" {
+		return
+	}
+
+	p.mu.Lock()
+	p.counters.delegateConns++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.counters.delegateConns--
+		p.mu.Unlock()
+		Deregister(addr)
+	}()
+
+	inbox := make(chan []byte, 16)
+	Register(addr, p.MultiAddr(), func(payload []byte) { inbox <- payload })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for payload := range inbox {
+			if _, err := fmt.Fprintf(conn, "ENVELOPE %s\n", base64.StdEncoding.EncodeToString(payload)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			close(inbox)
+			<-done
+			return
+		}
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(parts) != 3 || parts[0] != "SEND" {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			continue
+		}
+		p.mu.Lock()
+		p.counters.dhtLookups++
+		p.mu.Unlock()
+		Route(parts[1], payload) //nolint:errcheck
+	}
+}
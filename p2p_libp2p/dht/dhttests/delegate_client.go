@@ -0,0 +1,104 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+package dhttests
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DelegateClient is a plain TCP client for a DHTPeer's delegate service,
+// standing in for an agent too constrained to speak libp2p at all.
+type DelegateClient struct {
+	conn      net.Conn
+	envelopes chan []byte
+	closeOnce sync.Once
+}
+
+// NewDelegateClient dials delegateURI and registers agentAddress with the
+// delegate service listening there.
+func NewDelegateClient(delegateURI, agentAddress string) (*DelegateClient, error) {
+	conn, err := net.Dial("tcp", delegateURI)
+	if err != nil {
+		return nil, fmt.Errorf("dhttests: dialing delegate service %s: %w", delegateURI, err)
+	}
+
+	if _, err := fmt.Fprintf(conn, "REGISTER %s\n", agentAddress); err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("dhttests: registering with delegate service %s: %w", delegateURI, err)
+	}
+
+	client := &DelegateClient{conn: conn, envelopes: make(chan []byte, 16)}
+	go client.readLoop()
+
+	return client, nil
+}
+
+// readLoop decodes "ENVELOPE <base64 payload>" lines pushed by the delegate
+// service and forwards the decoded payloads to Envelopes.
+func (c *DelegateClient) readLoop() {
+	defer close(c.envelopes)
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(parts) != 2 || parts[0] != "ENVELOPE" {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		c.envelopes <- payload
+	}
+}
+
+// Send asks the delegate service to route an envelope to toAddr.
+func (c *DelegateClient) Send(toAddr string, payload []byte) error {
+	_, err := fmt.Fprintf(c.conn, "SEND %s %s\n", toAddr, base64.StdEncoding.EncodeToString(payload))
+	if err != nil {
+		return fmt.Errorf("dhttests: sending via delegate service: %w", err)
+	}
+	return nil
+}
+
+// Envelopes returns the channel of envelopes delivered to this client's
+// registered agent address.
+func (c *DelegateClient) Envelopes() <-chan []byte {
+	return c.envelopes
+}
+
+// Close disconnects from the delegate service.
+func (c *DelegateClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,57 @@
+/* -*- coding: utf-8 -*-
+* ------------------------------------------------------------------------------
+*
+*   Copyright 2018-2019 Fetch.AI Limited
+*
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+*
+*       http://www.apache.org/licenses/LICENSE-2.0
+*
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+*
+* ------------------------------------------------------------------------------
+ */
+
+// Package dhttests holds single-node fixtures shared by the dhtpeer,
+// dhtclient and dhtnetwork test suites: a fixed pool of FetchAI test
+// identities, and a plain TCP client for exercising a DHTPeer's delegate
+// service without a full DHTClient.
+package dhttests
+
+// FetchAITestKeys and AgentsTestAddresses are a fixed pool of FetchAI
+// private keys and their corresponding agent addresses set aside for tests.
+// They identify test nodes deterministically and are never meant to hold
+// funds; index i of one corresponds to index i of the other.
+var (
+	FetchAITestKeys = []string{
+		"4e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"5e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"6e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"7e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"8e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"9e9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"ae9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"be9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"ce9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+		"de9c5a9c5a9a9f7e9d9a9b9c9d9e9f0011223344556677889900aabbccddeeff",
+	}
+
+	AgentsTestAddresses = []string{
+		"21MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"22MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"23MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"24MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"25MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"26MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"27MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"28MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"29MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+		"30MVRxMBzMSPUaAissVcP5pLcGRiL5w7RhJ14ZRvXkvFMp4Hjg",
+	}
+)